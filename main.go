@@ -1,19 +1,31 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"image"
 	"image/color"
+	_ "image/jpeg"
 	"image/png"
 	"math"
 	"math/rand"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
 	tolerance = 0.001
+
+	// minBounces is the number of bounces a path always survives before
+	// Russian roulette termination kicks in.
+	minBounces = 3
+	// maxBounces is a hard safety cap so a path can never run away.
+	maxBounces = 64
 )
 
 type point struct {
@@ -40,6 +52,10 @@ func (p point) to(other point) direction {
 	return direction{other.x - p.x, other.y - p.y, other.z - p.z}
 }
 
+func (p point) add(d direction) point {
+	return point{p.x + d.dx, p.y + d.dy, p.z + d.dz}
+}
+
 func norm2(d direction) float64 {
 	return d.dx*d.dx + d.dy*d.dy + d.dz*d.dz
 }
@@ -48,6 +64,19 @@ func norm(d direction) float64 {
 	return math.Sqrt(norm2(d))
 }
 
+// axis returns the i'th component of p (0=x, 1=y, 2=z), used by the BVH
+// builder to compare centroids along a chosen split axis.
+func (p point) axis(i int) float64 {
+	switch i {
+	case 0:
+		return p.x
+	case 1:
+		return p.y
+	default:
+		return p.z
+	}
+}
+
 func (d *direction) normalize() {
 	n := norm(*d)
 	d.dx /= n
@@ -59,18 +88,63 @@ func dot(d1 direction, d2 direction) float64 {
 	return d1.dx*d2.dx + d1.dy*d2.dy + d1.dz*d2.dz
 }
 
-// rotate v 180 degrees around normal
-func rotateAround(v direction, normal direction) direction {
-	normal.normalize()
-	dotprod := normal.dx*v.dx + normal.dy*v.dy + normal.dz*v.dz
-	diff := direction{v.dx - dotprod*normal.dx, v.dy - dotprod*normal.dy, v.dz - dotprod*normal.dz}
-	rot := direction{v.dx - 2*diff.dx, v.dy - 2*diff.dy, v.dz - 2*diff.dz}
-	return rot
+func cross(d1 direction, d2 direction) direction {
+	return direction{
+		d1.dy*d2.dz - d1.dz*d2.dy,
+		d1.dz*d2.dx - d1.dx*d2.dz,
+		d1.dx*d2.dy - d1.dy*d2.dx,
+	}
+}
+
+// reflectDir mirrors incoming direction d about normal n, per the standard
+// reflect(d, n) = d - 2*dot(d, n)*n formula.
+func reflectDir(d direction, n direction) direction {
+	return d.add(n.scale(-2 * dot(d, n)))
+}
+
+// onb builds a right-handed orthonormal basis with w aligned to n, suitable
+// for mapping locally-sampled directions (e.g. cosine-weighted hemisphere
+// samples) into world space.
+func onb(n direction) (u, v, w direction) {
+	w = n
+	w.normalize()
+	a := direction{1, 0, 0}
+	if math.Abs(w.dx) > 0.9 {
+		a = direction{0, 1, 0}
+	}
+	v = cross(w, a)
+	v.normalize()
+	u = cross(v, w)
+	return u, v, w
+}
+
+// sampleCosineHemisphere draws a direction over the hemisphere around n with
+// probability proportional to cos(theta), as used for Lambertian scattering.
+func sampleCosineHemisphere(n direction) direction {
+	r1 := rand.Float64()
+	r2 := rand.Float64()
+	phi := 2 * math.Pi * r1
+	sinTheta := math.Sqrt(r2)
+	u, v, w := onb(n)
+	local := u.scale(math.Cos(phi) * sinTheta).add(v.scale(math.Sin(phi) * sinTheta))
+	return local.add(w.scale(math.Sqrt(1 - r2)))
+}
+
+// randomInUnitSphere rejection-samples a point within the unit ball, used to
+// fuzz a metal's mirror reflection.
+func randomInUnitSphere() direction {
+	for {
+		p := direction{2*rand.Float64() - 1, 2*rand.Float64() - 1, 2*rand.Float64() - 1}
+		if norm2(p) < 1 {
+			return p
+		}
+	}
 }
 
 type ray struct {
-	src point
-	dir direction
+	src  point
+	dir  direction
+	time float64 // point in the shutter interval this ray was cast at, for motion blur
 }
 
 func (r *ray) scale(factor float64) point {
@@ -90,32 +164,351 @@ func (r ray) flip() ray {
 }
 
 func (r ray) shiftBy(d direction) ray {
-	return ray{r.src, r.dir.add(d)}
+	return ray{src: r.src, dir: r.dir.add(d), time: r.time}
+}
+
+// materialKind selects which of material's scattering models applies.
+type materialKind int
+
+const (
+	Lambertian materialKind = iota
+	Metal
+	Dielectric
+	Emissive
+)
+
+// texture supplies a color for a point on an object's surface, given its
+// (u, v) texture coordinates and world position p.
+type texture interface {
+	valueAt(u, v float64, p point) color.Color
+}
+
+// solidColor is a texture that ignores u, v, and p and always returns the
+// same color; it's what a plain material.color used to be before textures.
+type solidColor struct {
+	c color.Color
+}
+
+func (s solidColor) valueAt(u, v float64, p point) color.Color {
+	return s.c
+}
+
+// solid wraps a plain color as a texture, for the common case of a material
+// with no spatial variation.
+func solid(c color.Color) texture {
+	return solidColor{c: c}
+}
+
+// checker alternates between two sub-textures based on the sign of
+// sin(scale*x)*sin(scale*y)*sin(scale*z), producing a 3D checkerboard that
+// doesn't require UV coordinates to look right on any surface.
+type checker struct {
+	odd, even texture
+	scale     float64
+}
+
+// newChecker builds a checker texture alternating between the two given
+// colors, with scale controlling the size of each square.
+func newChecker(odd, even color.Color, scale float64) texture {
+	return checker{odd: solid(odd), even: solid(even), scale: scale}
+}
+
+func (c checker) valueAt(u, v float64, p point) color.Color {
+	sines := math.Sin(c.scale*p.x) * math.Sin(c.scale*p.y) * math.Sin(c.scale*p.z)
+	if sines < 0 {
+		return c.odd.valueAt(u, v, p)
+	}
+	return c.even.valueAt(u, v, p)
+}
+
+// perlinPoints is the size of the perlin noise generator's permutation
+// tables and gradient vector lattice.
+const perlinPoints = 256
+
+// perlin is a gradient (Perlin) noise generator: a lattice of random unit
+// gradient vectors indexed by three independently shuffled permutation
+// tables, sampled with trilinear interpolation.
+type perlin struct {
+	ranvec              []direction
+	permX, permY, permZ []int
+}
+
+func newPerlin() *perlin {
+	ranvec := make([]direction, perlinPoints)
+	for i := range ranvec {
+		d := direction{2*rand.Float64() - 1, 2*rand.Float64() - 1, 2*rand.Float64() - 1}
+		d.normalize()
+		ranvec[i] = d
+	}
+	return &perlin{
+		ranvec: ranvec,
+		permX:  perlinGeneratePerm(),
+		permY:  perlinGeneratePerm(),
+		permZ:  perlinGeneratePerm(),
+	}
+}
+
+// perlinGeneratePerm returns a random permutation of [0, perlinPoints) via a
+// Fisher-Yates shuffle.
+func perlinGeneratePerm() []int {
+	p := make([]int, perlinPoints)
+	for i := range p {
+		p[i] = i
+	}
+	for i := len(p) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
+// noise evaluates the gradient noise field at p, in roughly [-1, 1].
+func (pn *perlin) noise(p point) float64 {
+	u := p.x - math.Floor(p.x)
+	v := p.y - math.Floor(p.y)
+	w := p.z - math.Floor(p.z)
+	i := int(math.Floor(p.x))
+	j := int(math.Floor(p.y))
+	k := int(math.Floor(p.z))
+
+	var c [2][2][2]direction
+	for di := 0; di < 2; di++ {
+		for dj := 0; dj < 2; dj++ {
+			for dk := 0; dk < 2; dk++ {
+				idx := pn.permX[(i+di)&255] ^ pn.permY[(j+dj)&255] ^ pn.permZ[(k+dk)&255]
+				c[di][dj][dk] = pn.ranvec[idx]
+			}
+		}
+	}
+	return trilinearInterp(c, u, v, w)
+}
+
+// trilinearInterp Hermite-smooths (u, v, w) and blends the gradient
+// contributions at the 8 surrounding lattice corners c.
+func trilinearInterp(c [2][2][2]direction, u, v, w float64) float64 {
+	uu := u * u * (3 - 2*u)
+	vv := v * v * (3 - 2*v)
+	ww := w * w * (3 - 2*w)
+	var accum float64
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			for k := 0; k < 2; k++ {
+				fi, fj, fk := float64(i), float64(j), float64(k)
+				weight := direction{u - fi, v - fj, w - fk}
+				accum += (fi*uu + (1-fi)*(1-uu)) *
+					(fj*vv + (1-fj)*(1-vv)) *
+					(fk*ww + (1-fk)*(1-ww)) *
+					dot(c[i][j][k], weight)
+			}
+		}
+	}
+	return accum
+}
+
+// turbulence sums depth octaves of noise at successively doubled frequency
+// and halved amplitude, giving a marbled, multi-scale variation.
+func (pn *perlin) turbulence(p point, depth int) float64 {
+	accum := 0.0
+	temp := p
+	weight := 1.0
+	for i := 0; i < depth; i++ {
+		accum += weight * pn.noise(temp)
+		weight *= 0.5
+		temp = point{temp.x * 2, temp.y * 2, temp.z * 2}
+	}
+	return math.Abs(accum)
+}
+
+// noiseTexture is a grayscale Perlin-noise texture, modulated through a sine
+// of p.z warped by 7 octaves of turbulence to get a marbled look rather than
+// plain static.
+type noiseTexture struct {
+	pn    *perlin
+	scale float64
+}
+
+// newNoiseTexture builds a fresh noise texture with its own random lattice;
+// scale controls how tightly the marbling is packed.
+func newNoiseTexture(scale float64) texture {
+	return noiseTexture{pn: newPerlin(), scale: scale}
+}
+
+func (n noiseTexture) valueAt(u, v float64, p point) color.Color {
+	t := 0.5 * (1 + math.Sin(n.scale*p.z+10*n.pn.turbulence(p, 7)))
+	g := clampByte(t)
+	return color.RGBA{g, g, g, 255}
+}
+
+// imageTexture samples a decoded image file, treating (u, v) as normalized
+// image coordinates with v=0 at the bottom and v=1 at the top.
+type imageTexture struct {
+	img image.Image
+}
+
+// newImageTexture decodes the image at path (PNG or JPEG) for use as a
+// texture.
+func newImageTexture(path string) (texture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return imageTexture{img: img}, nil
+}
+
+func (it imageTexture) valueAt(u, v float64, p point) color.Color {
+	bounds := it.img.Bounds()
+	x := clampInt(int(u*float64(bounds.Dx())), 0, bounds.Dx()-1)
+	y := clampInt(int((1-v)*float64(bounds.Dy())), 0, bounds.Dy()-1)
+	return it.img.At(bounds.Min.X+x, bounds.Min.Y+y)
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
 }
 
 type material struct {
-	luminous  bool // emits light
-	color     color.Color
-	roughness float64 // degree of scattering between 0-1
+	kind    materialKind
+	texture texture // albedo for Lambertian/Metal, emission for Emissive; unused for Dielectric
+	fuzz    float64 // Metal only: 0 is a pure mirror, larger blurs the reflection
+	ior     float64 // Dielectric only: index of refraction (glass ~1.5, water ~1.33)
+}
+
+// scatter samples a single outgoing path-tracing ray for a hit against this
+// material. ok is false when the surface doesn't scatter (e.g. it's
+// Emissive), in which case the path should terminate.
+func (m material) scatter(rayIn ray, rec hitRecord) (attenuation rgb, scattered ray, ok bool) {
+	switch m.kind {
+	case Lambertian:
+		outDir := sampleCosineHemisphere(rec.normal)
+		albedo := colorToRGB(m.texture.valueAt(rec.u, rec.v, rec.p))
+		return albedo, ray{src: rec.p, dir: outDir, time: rayIn.time}, true
+	case Metal:
+		reflected := reflectDir(rayIn.dir, rec.normal)
+		reflected.normalize()
+		outDir := reflected.add(randomInUnitSphere().scale(m.fuzz))
+		if dot(outDir, rec.normal) <= 0 {
+			// fuzz pushed the reflection below the surface; absorb instead.
+			return rgb{}, ray{}, false
+		}
+		albedo := colorToRGB(m.texture.valueAt(rec.u, rec.v, rec.p))
+		return albedo, ray{src: rec.p, dir: outDir, time: rayIn.time}, true
+	case Dielectric:
+		return m.scatterDielectric(rayIn, rec)
+	default: // Emissive
+		return rgb{}, ray{}, false
+	}
+}
+
+// scatterDielectric refracts or reflects rayIn off a dielectric surface of
+// index of refraction m.ior (the outside medium is assumed to be vacuum),
+// choosing between the two with Schlick's approximation to the Fresnel
+// reflectance.
+func (m material) scatterDielectric(rayIn ray, rec hitRecord) (rgb, ray, bool) {
+	unitDir := rayIn.dir
+	unitDir.normalize()
+	outwardNormal := rec.normal
+	etaiOverEtat := 1.0 / m.ior
+	cosTheta := -dot(unitDir, rec.normal)
+	if cosTheta < 0 {
+		// ray is leaving the dielectric rather than entering it
+		outwardNormal = rec.normal.flip()
+		etaiOverEtat = m.ior
+		cosTheta = -cosTheta
+	}
+	k := 1 - etaiOverEtat*etaiOverEtat*(1-cosTheta*cosTheta)
+	reflectProb := 1.0 // total internal reflection when k < 0
+	var refracted direction
+	if k >= 0 {
+		refracted = unitDir.scale(etaiOverEtat).add(outwardNormal.scale(etaiOverEtat*cosTheta - math.Sqrt(k)))
+		reflectProb = schlick(cosTheta, etaiOverEtat)
+	}
+	attenuation := rgb{1, 1, 1} // clear glass: no tinting, just bends the ray
+	if rand.Float64() < reflectProb {
+		return attenuation, ray{src: rec.p, dir: reflectDir(unitDir, outwardNormal), time: rayIn.time}, true
+	}
+	return attenuation, ray{src: rec.p, dir: refracted, time: rayIn.time}, true
+}
+
+// schlick approximates the Fresnel reflectance at the given incidence angle
+// for a surface with refractive index ratio refIdx.
+func schlick(cosine, refIdx float64) float64 {
+	r0 := (1 - refIdx) / (1 + refIdx)
+	r0 *= r0
+	return r0 + (1-r0)*math.Pow(1-cosine, 5)
+}
+
+// emitted returns the radiance this material contributes on its own,
+// independent of any scattered light.
+func (m material) emitted(rec hitRecord) rgb {
+	if m.kind == Emissive {
+		return colorToRGB(m.texture.valueAt(rec.u, rec.v, rec.p))
+	}
+	return rgb{}
+}
+
+// hitRecord describes where and how a ray struck an object. obj is the leaf
+// object that was actually hit, so BVH traversal can report a hit without
+// having to also expose the winning leaf through a separate return value.
+type hitRecord struct {
+	p      point
+	normal direction // unit outward normal
+	t      float64   // ray parameter at the hit
+	u, v   float64   // surface texture coordinates at the hit
+	obj    object
 }
 
 // object that rays can interact with
 type object interface {
-	intersect(ray) (intersection point, intersects bool)
-	reflect(ray, point) (outgoing []ray, weights []float64, color color.Color)
+	intersect(ray) (hitRecord, bool)
+	scatter(rayIn ray, rec hitRecord) (attenuation rgb, scattered ray, ok bool)
+	emitted(rec hitRecord) rgb
+	// aabb returns this object's axis-aligned bounding box as its min and
+	// max corners, used to build and traverse the scene's BVH.
+	aabb() (min, max point)
 }
 
+// ball optionally moves linearly between center0 at time0 and center1 at
+// time1, producing motion blur when sampled across a camera shutter
+// interval. A stationary ball just sets center0 == center1.
 type ball struct {
-	center point
-	radius float64
-	mat    material
+	center0, center1 point
+	time0, time1     float64
+	radius           float64
+	mat              material
 }
 
-func (b *ball) intersect(r ray) (point, bool) {
+// newBall builds a stationary ball, which is the common case.
+func newBall(center point, radius float64, mat material) *ball {
+	return &ball{center0: center, center1: center, radius: radius, mat: mat}
+}
+
+// centerAt returns the ball's center at the given ray time, linearly
+// interpolating between center0 and center1 over [time0, time1].
+func (b *ball) centerAt(t float64) point {
+	if b.time1 == b.time0 {
+		return b.center0
+	}
+	frac := (t - b.time0) / (b.time1 - b.time0)
+	return b.center0.add(b.center0.to(b.center1).scale(frac))
+}
+
+func (b *ball) intersect(r ray) (hitRecord, bool) {
+	center := b.centerAt(r.time)
 	// find intersection using quadratic formula
-	xx := r.src.x - b.center.x
-	yy := r.src.y - b.center.y
-	zz := r.src.z - b.center.z
+	xx := r.src.x - center.x
+	yy := r.src.y - center.y
+	zz := r.src.z - center.z
 	dx := r.dir.dx
 	dy := r.dir.dy
 	dz := r.dir.dz
@@ -124,205 +517,575 @@ func (b *ball) intersect(r ray) (point, bool) {
 	cc := xx*xx + yy*yy + zz*zz - b.radius*b.radius
 	radical := bb*bb - 4.0*aa*cc
 	if radical < 0 { // imaginary solutions
-		return point{}, false
+		return hitRecord{}, false
 	}
 	factor := (-bb - math.Sqrt(radical)) / (2.0 * aa)
 	if factor < tolerance { // inside ball already
-		return point{}, false
-	}
-	return r.scale(factor), true
-}
-
-func (b *ball) reflect(r ray, intersection point) ([]ray, []float64, color.Color) {
-	outgoing := make([]ray, 0)
-	weights := make([]float64, 0)
-	if !b.mat.luminous {
-		normal := getRay(intersection, b.center).flip()
-		outgoingDir := rotateAround(r.flip().dir, normal.dir)
-		outgoingRay := ray{src: intersection, dir: outgoingDir}
-		outgoing = append(outgoing, outgoingRay)
-		weights = append(weights, 1.0)
-		if b.mat.roughness > 0 {
-			// generate a random sphere of vectors around outgoingRay
-			if b.mat.roughness > 1 {
-				b.mat.roughness = 1
-			}
-			// find two vectors orthogonal to outgoing dir
-			var n1dir direction
-			var n2dirTemp direction
-			if outgoingDir.dx != 0 {
-				n1dir = direction{
-					-(outgoingDir.dy + outgoingDir.dz) / outgoingDir.dx,
-					1,
-					1}
-				n2dirTemp = direction{
-					-(outgoingDir.dy + 2*outgoingDir.dz) / outgoingDir.dx,
-					1,
-					2}
-			} else if outgoingDir.dy != 0 {
-				n1dir = direction{
-					1,
-					-(outgoingDir.dx + outgoingDir.dz) / outgoingDir.dy,
-					1}
-				n2dirTemp = direction{
-					2,
-					-(2*outgoingDir.dx + outgoingDir.dz) / outgoingDir.dy,
-					1}
-			} else { // outgoingDir.dz must be non-zero
-				n1dir = direction{
-					1,
-					1,
-					-(outgoingDir.dx + outgoingDir.dy) / outgoingDir.dz}
-				n2dirTemp = direction{
-					1,
-					2,
-					-(outgoingDir.dx + 2*outgoingDir.dy) / outgoingDir.dz}
-			}
-			n1dir.normalize()
-			// make n2dirTemp orthogonal to n1dir
-			parallelComp := dot(n1dir, n2dirTemp)
-			n2dir := direction{
-				n2dirTemp.dx - parallelComp*n1dir.dx,
-				n2dirTemp.dy - parallelComp*n1dir.dy,
-				n2dirTemp.dz - parallelComp*n1dir.dz,
-			}
-			n2dir.normalize()
-			for radius := 0.01; radius <= 5*b.mat.roughness; radius += 0.05 {
-				for angle := 0; angle < 360; angle += 5 {
-					radians := float64(angle) * (math.Pi / 180)
-					n1s := math.Cos(radians)
-					n2s := math.Sin(radians)
-					shiftDir := direction{
-						(radius * norm(outgoingDir)) * (n1s*n1dir.dx + n2s*n2dir.dx),
-						(radius * norm(outgoingDir)) * (n1s*n1dir.dy + n2s*n2dir.dy),
-						(radius * norm(outgoingDir)) * (n1s*n1dir.dz + n2s*n2dir.dz),
-					}
-					newDir := direction{
-						outgoingDir.dx + shiftDir.dx,
-						outgoingDir.dy + shiftDir.dy,
-						outgoingDir.dz + shiftDir.dz,
-					}
-					outgoing = append(outgoing, ray{intersection, newDir})
-					weights = append(weights, (1.0 / 72))
+		return hitRecord{}, false
+	}
+	p := r.scale(factor)
+	normal := center.to(p)
+	normal.normalize()
+	u, v := sphereUV(normal)
+	return hitRecord{p: p, normal: normal, t: factor, u: u, v: v, obj: b}, true
+}
+
+func (b *ball) scatter(rayIn ray, rec hitRecord) (rgb, ray, bool) {
+	return b.mat.scatter(rayIn, rec)
+}
+
+func (b *ball) emitted(rec hitRecord) rgb {
+	return b.mat.emitted(rec)
+}
+
+// sphereUV maps a unit outward normal on a sphere to (u, v) texture
+// coordinates, with u wrapping around the equator and v running from the
+// south pole (0) to the north pole (1).
+func sphereUV(n direction) (u, v float64) {
+	phi := math.Atan2(-n.dz, n.dx)
+	theta := math.Asin(n.dy)
+	u = 1 - (phi+math.Pi)/(2*math.Pi)
+	v = (theta + math.Pi/2) / math.Pi
+	return u, v
+}
+
+// aabb bounds the ball's full swept volume across [time0, time1], so the
+// BVH stays valid for a moving ball.
+func (b *ball) aabb() (point, point) {
+	r := b.radius
+	c0, c1 := b.center0, b.center1
+	min0, max0 := point{c0.x - r, c0.y - r, c0.z - r}, point{c0.x + r, c0.y + r, c0.z + r}
+	min1, max1 := point{c1.x - r, c1.y - r, c1.z - r}, point{c1.x + r, c1.y + r, c1.z + r}
+	return surroundingBox(min0, max0, min1, max1)
+}
+
+// triangle is a mesh triangle with per-vertex normals; for flat shading all
+// three normals are the same face normal.
+type triangle struct {
+	v0, v1, v2 point
+	n0, n1, n2 direction
+	mat        material
+}
+
+// newTriangle builds a flat-shaded triangle, deriving its normal from the
+// winding of v0, v1, v2.
+func newTriangle(v0, v1, v2 point, mat material) *triangle {
+	n := cross(v0.to(v1), v0.to(v2))
+	n.normalize()
+	return &triangle{v0: v0, v1: v1, v2: v2, n0: n, n1: n, n2: n, mat: mat}
+}
+
+// newSmoothTriangle builds a triangle that interpolates the given per-vertex
+// normals across its surface.
+func newSmoothTriangle(v0, v1, v2 point, n0, n1, n2 direction, mat material) *triangle {
+	return &triangle{v0: v0, v1: v1, v2: v2, n0: n0, n1: n1, n2: n2, mat: mat}
+}
+
+// intersect implements the Möller-Trumbore ray/triangle intersection test.
+func (t *triangle) intersect(r ray) (hitRecord, bool) {
+	e1 := t.v0.to(t.v1)
+	e2 := t.v0.to(t.v2)
+	h := cross(r.dir, e2)
+	a := dot(e1, h)
+	if math.Abs(a) < tolerance { // ray parallel to the triangle
+		return hitRecord{}, false
+	}
+	f := 1.0 / a
+	s := t.v0.to(r.src)
+	u := f * dot(s, h)
+	if u < 0 || u > 1 {
+		return hitRecord{}, false
+	}
+	q := cross(s, e1)
+	v := f * dot(r.dir, q)
+	if v < 0 || u+v > 1 {
+		return hitRecord{}, false
+	}
+	factor := f * dot(e2, q)
+	if factor < tolerance {
+		return hitRecord{}, false
+	}
+	w := 1 - u - v
+	normal := t.n0.scale(w).add(t.n1.scale(u)).add(t.n2.scale(v))
+	normal.normalize()
+	return hitRecord{p: r.scale(factor), normal: normal, t: factor, u: u, v: v, obj: t}, true
+}
+
+func (t *triangle) scatter(rayIn ray, rec hitRecord) (rgb, ray, bool) {
+	return t.mat.scatter(rayIn, rec)
+}
+
+func (t *triangle) emitted(rec hitRecord) rgb {
+	return t.mat.emitted(rec)
+}
+
+func (t *triangle) aabb() (point, point) {
+	min := point{
+		minOf3(t.v0.x, t.v1.x, t.v2.x),
+		minOf3(t.v0.y, t.v1.y, t.v2.y),
+		minOf3(t.v0.z, t.v1.z, t.v2.z),
+	}
+	max := point{
+		maxOf3(t.v0.x, t.v1.x, t.v2.x),
+		maxOf3(t.v0.y, t.v1.y, t.v2.y),
+		maxOf3(t.v0.z, t.v1.z, t.v2.z),
+	}
+	return min, max
+}
+
+func minOf3(a, b, c float64) float64 {
+	return math.Min(a, math.Min(b, c))
+}
+
+func maxOf3(a, b, c float64) float64 {
+	return math.Max(a, math.Max(b, c))
+}
+
+// resolveOBJIndex converts a 1-based OBJ index (or, if negative, an index
+// relative to the end of the list as OBJ allows) into a 0-based slice index.
+func resolveOBJIndex(idx, length int) int {
+	if idx < 0 {
+		return length + idx
+	}
+	return idx - 1
+}
+
+// loadOBJ parses the v/vn/f lines of a Wavefront OBJ file at path and
+// returns its faces as triangles sharing mat, fan-triangulating any face
+// with more than three vertices. Faces that carry vertex normals (v/vt/vn
+// or v//vn) get smooth-shaded triangles; faces with bare vertex indices
+// fall back to flat shading.
+func loadOBJ(path string, mat material) ([]object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var verts []point
+	var normals []direction
+	var objects []object
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			x, _ := strconv.ParseFloat(fields[1], 64)
+			y, _ := strconv.ParseFloat(fields[2], 64)
+			z, _ := strconv.ParseFloat(fields[3], 64)
+			verts = append(verts, point{x, y, z})
+		case "vn":
+			x, _ := strconv.ParseFloat(fields[1], 64)
+			y, _ := strconv.ParseFloat(fields[2], 64)
+			z, _ := strconv.ParseFloat(fields[3], 64)
+			n := direction{x, y, z}
+			n.normalize()
+			normals = append(normals, n)
+		case "f":
+			vIdx := make([]int, 0, len(fields)-1)
+			nIdx := make([]int, 0, len(fields)-1)
+			haveNormals := true
+			for _, ref := range fields[1:] {
+				parts := strings.Split(ref, "/")
+				vi, _ := strconv.Atoi(parts[0])
+				vIdx = append(vIdx, resolveOBJIndex(vi, len(verts)))
+				if len(parts) == 3 && parts[2] != "" {
+					ni, _ := strconv.Atoi(parts[2])
+					nIdx = append(nIdx, resolveOBJIndex(ni, len(normals)))
+				} else {
+					haveNormals = false
 				}
 			}
-			// if math.Abs(dot(n2dir, outgoingDir)) > tolerance {
-			// 	fmt.Printf("%v*%v=%v not orthogonal!\n", n2dir, outgoingDir, dot(n2dir, outgoingDir))
-			// }
-			// if dot(n1dir, outgoingDir) > tolerance {
-			// 	fmt.Printf("%v*%v=%v not orthogonal!\n", n1dir, outgoingDir, dot(n1dir, outgoingDir))
-			// }
-			// if dot(n1dir, n2dir) > tolerance {
-			// 	fmt.Printf("%v*%v=%v not orthogonal!\n", n1dir, n2dir, dot(n1dir, n2dir))
-			// }
-			/*
-				samples := 2000
-				for i := 0; i < samples; i++ {
-					deflectionRadius2 := rand.Float64() / b.mat.roughness * norm2(outgoingDir)
-					randomDir := direction{
-						dx: rand.Float64(),
-						dy: rand.Float64(),
-						dz: rand.Float64(),
-					}
-					randomNorm2 := norm2(randomDir)
-					randomDir = randomDir.scale(deflectionRadius2 / randomNorm2)
-					outgoing = append(outgoing, outgoingRay.shiftBy(randomDir))
+			for i := 1; i+1 < len(vIdx); i++ { // fan triangulation
+				v0, v1, v2 := verts[vIdx[0]], verts[vIdx[i]], verts[vIdx[i+1]]
+				if haveNormals {
+					n0, n1, n2 := normals[nIdx[0]], normals[nIdx[i]], normals[nIdx[i+1]]
+					objects = append(objects, newSmoothTriangle(v0, v1, v2, n0, n1, n2, mat))
+				} else {
+					objects = append(objects, newTriangle(v0, v1, v2, mat))
 				}
-			*/
+			}
 		}
 	}
-	return outgoing, weights, b.mat.color
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// rgb is a linear-space color accumulator used while path tracing; unlike
+// color.Color it isn't clamped to 8 bits until the final pixel write.
+type rgb struct {
+	r, g, b float64
+}
+
+func (c rgb) scale(factor float64) rgb {
+	return rgb{factor * c.r, factor * c.g, factor * c.b}
+}
+
+func (c rgb) add(c2 rgb) rgb {
+	return rgb{c.r + c2.r, c.g + c2.g, c.b + c2.b}
+}
+
+func (c rgb) mul(c2 rgb) rgb {
+	return rgb{c.r * c2.r, c.g * c2.g, c.b * c2.b}
+}
+
+func colorToRGB(c color.Color) rgb {
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return rgb{}
+	}
+	return rgb{float64(r) / float64(a), float64(g) / float64(a), float64(b) / float64(a)}
+}
+
+func clampByte(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return uint8(v*255 + 0.5)
+}
+
+// randomInUnitDisk rejection-samples a point on the unit disk in the xy
+// plane (z always 0), used to jitter ray origins across a camera's lens.
+func randomInUnitDisk() direction {
+	for {
+		p := direction{2*rand.Float64() - 1, 2*rand.Float64() - 1, 0}
+		if norm2(p) < 1 {
+			return p
+		}
+	}
+}
+
+// camera is a positionable thin-lens camera: it exposes getRay(u, v) over
+// the image plane in [0, 1]^2, sampling the lens for depth of field and the
+// shutter interval for motion blur.
+type camera struct {
+	origin               point
+	lowerLeft            point
+	horizontal, vertical direction
+	u, v, w              direction // camera basis; w points from lookAt to lookFrom
+	lensRadius           float64
+	time0, time1         float64
+}
+
+// newCamera builds a camera looking from lookFrom towards lookAt, with
+// vUp fixing the roll, vfovDeg the vertical field of view in degrees, and
+// aspect the image's width/height ratio. aperture and focusDist control
+// depth of field: rays are jittered across a lens of radius aperture/2 and
+// focused at focusDist along the view direction. [time0, time1] is the
+// shutter interval rays are sampled across for motion blur.
+func newCamera(lookFrom, lookAt point, vUp direction, vfovDeg, aspect, aperture, focusDist, time0, time1 float64) *camera {
+	theta := vfovDeg * math.Pi / 180
+	halfHeight := math.Tan(theta / 2)
+	halfWidth := aspect * halfHeight
+
+	w := lookAt.to(lookFrom)
+	w.normalize()
+	u := cross(vUp, w)
+	u.normalize()
+	v := cross(w, u)
+
+	horizontal := u.scale(2 * halfWidth * focusDist)
+	vertical := v.scale(2 * halfHeight * focusDist)
+	lowerLeft := lookFrom.
+		add(horizontal.scale(-0.5)).
+		add(vertical.scale(-0.5)).
+		add(w.scale(-focusDist))
+
+	return &camera{
+		origin:     lookFrom,
+		lowerLeft:  lowerLeft,
+		horizontal: horizontal,
+		vertical:   vertical,
+		u:          u,
+		v:          v,
+		w:          w,
+		lensRadius: aperture / 2,
+		time0:      time0,
+		time1:      time1,
+	}
+}
+
+func (c *camera) getRay(s, t float64) ray {
+	rd := randomInUnitDisk().scale(c.lensRadius)
+	offset := c.u.scale(rd.dx).add(c.v.scale(rd.dy))
+	src := c.origin.add(offset)
+	target := c.lowerLeft.add(c.horizontal.scale(s)).add(c.vertical.scale(t))
+	tm := c.time0 + rand.Float64()*(c.time1-c.time0)
+	return ray{src: src, dir: src.to(target), time: tm}
+}
+
+// bvhNode is an internal node of a bounding volume hierarchy over a scene's
+// objects. It implements object itself so traversal is just intersect
+// recursion, but scatter/emitted are never called on it directly: the
+// winning leaf is reported through hitRecord.obj.
+type bvhNode struct {
+	left, right object
+	min, max    point
+}
+
+func centroid(o object) point {
+	min, max := o.aabb()
+	return point{(min.x + max.x) / 2, (min.y + max.y) / 2, (min.z + max.z) / 2}
+}
+
+func surroundingBox(aMin, aMax, bMin, bMax point) (point, point) {
+	min := point{math.Min(aMin.x, bMin.x), math.Min(aMin.y, bMin.y), math.Min(aMin.z, bMin.z)}
+	max := point{math.Max(aMax.x, bMax.x), math.Max(aMax.y, bMax.y), math.Max(aMax.z, bMax.z)}
+	return min, max
+}
+
+// longestAxis returns the axis (0=x, 1=y, 2=z) along which objs' centroids
+// are most spread out, used to choose the BVH split axis.
+func longestAxis(objs []object) int {
+	minC := point{math.Inf(1), math.Inf(1), math.Inf(1)}
+	maxC := point{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, o := range objs {
+		c := centroid(o)
+		minC = point{math.Min(minC.x, c.x), math.Min(minC.y, c.y), math.Min(minC.z, c.z)}
+		maxC = point{math.Max(maxC.x, c.x), math.Max(maxC.y, c.y), math.Max(maxC.z, c.z)}
+	}
+	extent := point{maxC.x - minC.x, maxC.y - minC.y, maxC.z - minC.z}
+	switch {
+	case extent.x >= extent.y && extent.x >= extent.z:
+		return 0
+	case extent.y >= extent.z:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// buildBVH recursively splits objs along the longest axis at the median
+// centroid, returning a binary BVH (or the bare object itself for a
+// single-object subtree).
+func buildBVH(objs []object) object {
+	switch len(objs) {
+	case 0:
+		return nil
+	case 1:
+		return objs[0]
+	default:
+		axis := longestAxis(objs)
+		sort.Slice(objs, func(i, j int) bool {
+			return centroid(objs[i]).axis(axis) < centroid(objs[j]).axis(axis)
+		})
+		mid := len(objs) / 2
+		left := buildBVH(objs[:mid])
+		right := buildBVH(objs[mid:])
+		lMin, lMax := left.aabb()
+		rMin, rMax := right.aabb()
+		boxMin, boxMax := surroundingBox(lMin, lMax, rMin, rMax)
+		return &bvhNode{left: left, right: right, min: boxMin, max: boxMax}
+	}
+}
+
+// hitAABB is a slab-test ray/box intersection, used to prune BVH subtrees
+// that a ray can't possibly hit.
+func hitAABB(min, max point, r ray, tMin, tMax float64) bool {
+	origin := [3]float64{r.src.x, r.src.y, r.src.z}
+	dir := [3]float64{r.dir.dx, r.dir.dy, r.dir.dz}
+	lo := [3]float64{min.x, min.y, min.z}
+	hi := [3]float64{max.x, max.y, max.z}
+	for axis := 0; axis < 3; axis++ {
+		invD := 1.0 / dir[axis]
+		t0 := (lo[axis] - origin[axis]) * invD
+		t1 := (hi[axis] - origin[axis]) * invD
+		if invD < 0 {
+			t0, t1 = t1, t0
+		}
+		if t0 > tMin {
+			tMin = t0
+		}
+		if t1 < tMax {
+			tMax = t1
+		}
+		if tMax <= tMin {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *bvhNode) aabb() (point, point) {
+	return n.min, n.max
+}
+
+func (n *bvhNode) intersect(r ray) (hitRecord, bool) {
+	if !hitAABB(n.min, n.max, r, tolerance, math.Inf(1)) {
+		return hitRecord{}, false
+	}
+	recL, hitL := n.left.intersect(r)
+	recR, hitR := n.right.intersect(r)
+	switch {
+	case hitL && hitR:
+		if recL.t < recR.t {
+			return recL, true
+		}
+		return recR, true
+	case hitL:
+		return recL, true
+	case hitR:
+		return recR, true
+	default:
+		return hitRecord{}, false
+	}
+}
+
+func (n *bvhNode) scatter(ray, hitRecord) (rgb, ray, bool) {
+	panic("bvhNode is an internal node; scatter is dispatched through hitRecord.obj")
+}
+
+func (n *bvhNode) emitted(hitRecord) rgb {
+	panic("bvhNode is an internal node; emitted is dispatched through hitRecord.obj")
 }
 
 type scene struct {
 	objects    []object
+	bvh        object
 	background color.Color
 }
 
-func (s *scene) getColor(r ray) color.Color {
-	minDist := -1.0
-	minI := -1
-	var ipnt point
-	for i, o := range s.objects {
-		if intersection, intersects := o.intersect(r); intersects {
-			dist := norm(r.src.to(intersection))
-			if minDist < 0 || dist < minDist {
-				minDist = dist
-				minI = i
-				ipnt = intersection
-			}
-		}
+// build constructs the scene's BVH over objects. Call once before render.
+func (s *scene) build() {
+	s.bvh = buildBVH(s.objects)
+}
+
+// trace estimates the radiance arriving along r via unidirectional path
+// tracing: it walks the scattering chain, accumulating emission weighted by
+// the path's throughput, and terminates either when a ray escapes the scene,
+// hits a non-scattering (emissive) surface, or is killed by Russian
+// roulette.
+func (s *scene) trace(r ray) rgb {
+	if s.bvh == nil {
+		return colorToRGB(s.background)
 	}
-	if minI != -1 {
-		collidesWith := s.objects[minI]
-		outgoing, weights, cc := collidesWith.reflect(r, ipnt)
-		if len(outgoing) == 0 { // luminous
-			return cc
+	throughput := rgb{1, 1, 1}
+	accum := rgb{}
+	cur := r
+	for bounce := 0; bounce < maxBounces; bounce++ {
+		rec, hit := s.bvh.intersect(cur)
+		if !hit {
+			return accum.add(throughput.mul(colorToRGB(s.background)))
 		}
-		totalWeight := 0.0
-		r, g, b, a := cc.RGBA()
-		finalR := 0.0
-		finalG := 0.0
-		finalB := 0.0
-		for i := 0; i < len(outgoing); i++ {
-			outgoingRay := outgoing[i]
-			weight := weights[i]
-			totalWeight += weight
-			nc := s.getColor(outgoingRay)
-			nr, ng, nb, na := nc.RGBA()
-			finalR += float64(nr) / float64(na) * float64(r) * weight
-			finalG += float64(ng) / float64(na) * float64(g) * weight
-			finalB += float64(nb) / float64(na) * float64(b) * weight
+		accum = accum.add(throughput.mul(rec.obj.emitted(rec)))
+		attenuation, scattered, ok := rec.obj.scatter(cur, rec)
+		if !ok {
+			break
 		}
-		finalR /= totalWeight
-		finalG /= totalWeight
-		finalB /= totalWeight
-		return color.RGBA{
-			uint8(finalR / float64(a) * 255),
-			uint8(finalG / float64(a) * 255),
-			uint8(finalB / float64(a) * 255),
-			255,
+		throughput = throughput.mul(attenuation)
+		if bounce >= minBounces {
+			p := math.Max(throughput.r, math.Max(throughput.g, throughput.b))
+			if p <= 0 || rand.Float64() > p {
+				break
+			}
+			throughput = throughput.scale(1 / p)
 		}
+		cur = scattered
 	}
-	return s.background
+	return accum
+}
+
+// renderTile is a disjoint rectangle of pixels ([row0,row1) x [col0,col1))
+// handed to a single worker; tiles never overlap, so workers never need to
+// coordinate with each other while writing the image.
+type renderTile struct {
+	row0, row1, col0, col1 int
 }
 
-func (s *scene) render(output string) {
-	origin := point{0, 0, 0}
+// setPixel writes a gamma-corrected linear color straight into img's pixel
+// backing slice. Safe to call without locking as long as no two goroutines
+// ever touch the same (x, y).
+func setPixel(img *image.RGBA, x, y int, c rgb) {
+	gamma := rgb{math.Sqrt(math.Max(c.r, 0)), math.Sqrt(math.Max(c.g, 0)), math.Sqrt(math.Max(c.b, 0))}
+	offset := img.PixOffset(x, y)
+	img.Pix[offset+0] = clampByte(gamma.r)
+	img.Pix[offset+1] = clampByte(gamma.g)
+	img.Pix[offset+2] = clampByte(gamma.b)
+	img.Pix[offset+3] = 255
+}
+
+// render traces the scene through cam into a width x height PNG at output,
+// using a fixed pool of runtime.NumCPU() workers pulling tiles from a job
+// channel. It runs `passes` progressive passes of samplesPerPixel samples
+// each, gamma-correcting and rewriting output after every pass so the image
+// visibly refines over time instead of appearing only once fully rendered.
+func (s *scene) render(cam *camera, output string, samplesPerPixel, passes int) {
 	width := 2000
 	height := 1000
 	img := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{width, height}})
-	imgLock := sync.Mutex{}
-	imgGroup := sync.WaitGroup{}
-	xMin := -0.5
-	xMax := 0.5
-	yMin := -0.25
-	yMax := 0.25
-	z := 1.0
+	totals := make([]rgb, width*height) // running sum of samples per pixel, across all passes so far
 
 	granularity := 200 // should divide width and height
+	var tiles []renderTile
 	for row := 0; row < height; row += granularity {
 		for col := 0; col < width; col += granularity {
-			imgGroup.Add(1)
-			go func(initr int, initc int) {
-				for r := initr; r < initr+granularity; r++ {
-					for c := initc; c < initc+granularity; c++ {
-						x := xMin + float64(c)*(xMax-xMin)/float64(width)
-						y := yMin + float64(r)*(yMax-yMin)/float64(height)
-						ray := getRay(origin, point{x, y, z})
-						color := s.getColor(ray)
-						imgLock.Lock()
-						img.Set(c, r, color)
-						imgLock.Unlock()
+			tiles = append(tiles, renderTile{row, row + granularity, col, col + granularity})
+		}
+	}
+
+	numWorkers := runtime.NumCPU()
+
+	for pass := 1; pass <= passes; pass++ {
+		tileCh := make(chan renderTile, len(tiles))
+		for _, tile := range tiles {
+			tileCh <- tile
+		}
+		close(tileCh)
+
+		progress := make(chan struct{}, len(tiles))
+		var workers sync.WaitGroup
+		for w := 0; w < numWorkers; w++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for tile := range tileCh {
+					// a private accumulator for this tile's new samples this
+					// pass; no locking is needed since tiles never overlap.
+					local := make([]rgb, (tile.row1-tile.row0)*(tile.col1-tile.col0))
+					tileWidth := tile.col1 - tile.col0
+					for row := tile.row0; row < tile.row1; row++ {
+						for col := tile.col0; col < tile.col1; col++ {
+							u := float64(col) / float64(width)
+							v := float64(row) / float64(height)
+							var sum rgb
+							for i := 0; i < samplesPerPixel; i++ {
+								sum = sum.add(s.trace(cam.getRay(u, v)))
+							}
+							local[(row-tile.row0)*tileWidth+(col-tile.col0)] = sum
+						}
+					}
+					for row := tile.row0; row < tile.row1; row++ {
+						for col := tile.col0; col < tile.col1; col++ {
+							idx := row*width + col
+							totals[idx] = totals[idx].add(local[(row-tile.row0)*tileWidth+(col-tile.col0)])
+							setPixel(img, col, row, totals[idx].scale(1.0/float64(pass*samplesPerPixel)))
+						}
 					}
+					progress <- struct{}{}
 				}
-				imgGroup.Done()
-			}(row, col)
+			}()
+		}
+
+		for done := 1; done <= len(tiles); done++ {
+			<-progress
+			fmt.Printf("\rpass %d/%d: %d%%", pass, passes, done*100/len(tiles))
 		}
+		workers.Wait()
+		fmt.Println()
+
+		f, _ := os.Create(output)
+		png.Encode(f, img)
+		f.Close()
 	}
-	imgGroup.Wait()
-	f, _ := os.Create(output)
-	png.Encode(f, img)
 }
 
 func main() {
@@ -330,25 +1093,25 @@ func main() {
 	rand.Seed(42)
 	s := scene{
 		objects: []object{
-			// &ball{center: point{2, 2, 25}, radius: 1, mat: material{
-			// 	luminous:  false,
-			// 	color:     color.RGBA{255, 0, 0, 255},
-			// 	roughness: 0},
-			// },
-			&ball{center: point{-10, 0, 50}, radius: 1, mat: material{
-				luminous:  false,
-				color:     color.RGBA{0, 255, 0, 255},
-				roughness: 1},
-			},
-			&ball{center: point{30, 0, 40}, radius: 30, mat: material{
-				luminous:  true,
-				color:     color.RGBA{255, 255, 255, 255},
-				roughness: 0},
-			},
+			newBall(point{2, 2, 25}, 1, material{
+				kind:    Metal,
+				texture: solid(color.RGBA{255, 0, 0, 255}),
+				fuzz:    0}),
+			newBall(point{-10, 0, 50}, 1, material{
+				kind:    Lambertian,
+				texture: solid(color.RGBA{0, 255, 0, 255})}),
+			newBall(point{30, 0, 40}, 30, material{
+				kind:    Emissive,
+				texture: solid(color.RGBA{255, 255, 255, 255})}),
 		},
 		background: color.RGBA{32, 32, 32, 255}}
+	s.build()
+
+	vfov := 2 * math.Atan(0.25) * 180 / math.Pi // matches the old fixed -0.5..0.5/-0.25..0.25 viewport at z=1
+	cam := newCamera(point{0, 0, 0}, point{0, 0, 1}, direction{0, 1, 0}, vfov, 2.0, 0, 1, 0, 1)
+
 	start := time.Now()
-	s.render("image.png")
+	s.render(cam, "image.png", 16, 4)
 	elapsed := time.Now().Sub(start)
 	fmt.Printf("Time elapsed: %v\n", elapsed)
 }